@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/i4ki/ubt-server/pkg/proto"
+)
+
+// LogFormat is the timestamp layout shared by every chat message, player- or
+// server-generated, so clients only need to parse one format.
+const LogFormat = time.RFC3339
+
+type (
+	// ChatComponent is one node of a Minecraft-style chat component tree:
+	// a run of text plus its own styling, optionally followed by further
+	// styled runs in Extra.
+	ChatComponent struct {
+		Text   string          `json:"text"`
+		Color  string          `json:"color,omitempty"`
+		Bold   bool            `json:"bold,omitempty"`
+		Italic bool            `json:"italic,omitempty"`
+		Extra  []ChatComponent `json:"extra,omitempty"`
+	}
+
+	// ClickEvent is a clickable action attached to a chat message, e.g.
+	// running a command or copying text to the clipboard. Clients are free
+	// to render it as a button or ignore it entirely.
+	ClickEvent struct {
+		Action string `json:"action"`
+		Value  string `json:"value"`
+	}
+
+	// chatRequest is what a client sends: legacy '&'-coded text plus an
+	// optional "action:value" click shorthand, e.g. "run_command:/rematch".
+	chatRequest struct {
+		Body  string `json:"body"`
+		Click string `json:"click,omitempty"`
+	}
+
+	// chatMessage is what the server fans out: the sender, the parsed
+	// component tree, an optional click event, and a LogFormat timestamp.
+	chatMessage struct {
+		From      string        `json:"from"`
+		Body      ChatComponent `json:"body"`
+		Click     *ClickEvent   `json:"click,omitempty"`
+		Timestamp string        `json:"timestamp"`
+	}
+)
+
+// legacyColors maps Minecraft's single-character '&' color codes to their
+// names.
+var legacyColors = map[byte]string{
+	'0': "black", '1': "dark_blue", '2': "dark_green", '3': "dark_aqua",
+	'4': "dark_red", '5': "dark_purple", '6': "gold", '7': "gray",
+	'8': "dark_gray", '9': "blue", 'a': "green", 'b': "aqua",
+	'c': "red", 'd': "light_purple", 'e': "yellow", 'f': "white",
+}
+
+// parseLegacyText turns '&'-coded text (colors, &l bold, &o italic, &r
+// reset) into a ChatComponent tree, the small formatting subset clients are
+// expected to render or ignore.
+func parseLegacyText(s string) ChatComponent {
+	root := ChatComponent{}
+
+	var (
+		color        string
+		bold, italic bool
+		buf          strings.Builder
+	)
+
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+
+		root.Extra = append(root.Extra, ChatComponent{
+			Text:   buf.String(),
+			Color:  color,
+			Bold:   bold,
+			Italic: italic,
+		})
+		buf.Reset()
+	}
+
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '&' || i+1 >= len(runes) {
+			buf.WriteRune(runes[i])
+			continue
+		}
+
+		code := runes[i+1]
+
+		switch {
+		case code == 'l':
+			flush()
+			bold = true
+		case code == 'o':
+			flush()
+			italic = true
+		case code == 'r':
+			flush()
+			color, bold, italic = "", false, false
+		default:
+			name, ok := legacyColors[byte(code)]
+
+			if !ok || code >= 0x80 {
+				buf.WriteRune(runes[i])
+				continue
+			}
+
+			flush()
+			color, bold, italic = name, false, false
+		}
+
+		i++
+	}
+
+	flush()
+
+	return root
+}
+
+// parseClickEvent decodes the "action:value" shorthand clients send for
+// clickable actions, e.g. "run_command:/rematch" or
+// "copy_to_clipboard:<seed>". Returns nil if s is empty or the action isn't
+// recognised.
+func parseClickEvent(s string) *ClickEvent {
+	if s == "" {
+		return nil
+	}
+
+	action, value, ok := strings.Cut(s, ":")
+
+	if !ok {
+		return nil
+	}
+
+	switch action {
+	case "run_command", "copy_to_clipboard":
+		return &ClickEvent{Action: action, Value: value}
+	default:
+		return nil
+	}
+}
+
+// handleChatFrame parses a Chat frame's payload from a player and fans the
+// resulting message out to the other player and any spectators.
+func handleChatFrame(room *Room, from *Player, payload []byte) {
+	var req chatRequest
+
+	if err := json.Unmarshal(payload, &req); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
+
+	msg := chatMessage{
+		From:      from.Name,
+		Body:      parseLegacyText(req.Body),
+		Click:     parseClickEvent(req.Click),
+		Timestamp: time.Now().Format(LogFormat),
+	}
+
+	broadcastChat(room, msg, playerName(from.Name))
+}
+
+// systemChat emits a server-generated chat message ("Player X selected
+// Drax", "Round 1 begins") to everyone in room.
+func systemChat(room *Room, body string) {
+	msg := chatMessage{
+		From:      "server",
+		Body:      ChatComponent{Text: body, Color: "gray", Italic: true},
+		Timestamp: time.Now().Format(LogFormat),
+	}
+
+	broadcastChat(room, msg, "")
+}
+
+// broadcastChat sends msg as a Chat frame to every player in room except
+// exclude (pass "" to include everyone) and to every spectator.
+func broadcastChat(room *Room, msg chatMessage, exclude playerName) {
+	data, err := json.Marshal(msg)
+
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
+
+	room.Lock()
+
+	conns := make([]net.Conn, 0, len(room.players))
+
+	for name, p := range room.players {
+		if name == exclude {
+			continue
+		}
+
+		conns = append(conns, p.conn)
+	}
+
+	room.Unlock()
+
+	for _, c := range conns {
+		proto.WritePacket(c, proto.Chat, data)
+	}
+
+	room.spectatorsMu.Lock()
+	specs := append([]*Spectator(nil), room.spectators...)
+	room.spectatorsMu.Unlock()
+
+	for _, s := range specs {
+		proto.WritePacket(s.conn, proto.Chat, data)
+	}
+}
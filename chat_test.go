@@ -0,0 +1,77 @@
+package main
+
+import "testing"
+
+func TestParseLegacyTextPlain(t *testing.T) {
+	got := parseLegacyText("hello")
+
+	if len(got.Extra) != 1 || got.Extra[0].Text != "hello" {
+		t.Fatalf("Extra = %+v, want a single \"hello\" run", got.Extra)
+	}
+}
+
+func TestParseLegacyTextColorAndReset(t *testing.T) {
+	got := parseLegacyText("&ared&rplain")
+
+	if len(got.Extra) != 2 {
+		t.Fatalf("Extra = %+v, want 2 runs", got.Extra)
+	}
+
+	if got.Extra[0].Text != "red" || got.Extra[0].Color != "green" {
+		t.Errorf("Extra[0] = %+v, want text %q color %q", got.Extra[0], "red", "green")
+	}
+
+	if got.Extra[1].Text != "plain" || got.Extra[1].Color != "" {
+		t.Errorf("Extra[1] = %+v, want text %q with no color", got.Extra[1], "plain")
+	}
+}
+
+func TestParseLegacyTextBoldItalic(t *testing.T) {
+	got := parseLegacyText("&l&obold italic")
+
+	if len(got.Extra) != 1 {
+		t.Fatalf("Extra = %+v, want 1 run", got.Extra)
+	}
+
+	if !got.Extra[0].Bold || !got.Extra[0].Italic {
+		t.Errorf("Extra[0] = %+v, want bold and italic", got.Extra[0])
+	}
+}
+
+func TestParseLegacyTextUnknownCodeIsLiteral(t *testing.T) {
+	got := parseLegacyText("&z")
+
+	if len(got.Extra) != 1 || got.Extra[0].Text != "&z" {
+		t.Fatalf("Extra = %+v, want the literal \"&z\" preserved", got.Extra)
+	}
+}
+
+func TestParseClickEventValid(t *testing.T) {
+	got := parseClickEvent("run_command:/rematch")
+
+	if got == nil {
+		t.Fatal("got = nil, want a ClickEvent")
+	}
+
+	if got.Action != "run_command" || got.Value != "/rematch" {
+		t.Errorf("got = %+v, want action %q value %q", got, "run_command", "/rematch")
+	}
+}
+
+func TestParseClickEventEmpty(t *testing.T) {
+	if got := parseClickEvent(""); got != nil {
+		t.Errorf("got = %+v, want nil for empty input", got)
+	}
+}
+
+func TestParseClickEventUnrecognizedAction(t *testing.T) {
+	if got := parseClickEvent("launch_missiles:now"); got != nil {
+		t.Errorf("got = %+v, want nil for an unrecognized action", got)
+	}
+}
+
+func TestParseClickEventNoSeparator(t *testing.T) {
+	if got := parseClickEvent("run_command"); got != nil {
+		t.Errorf("got = %+v, want nil when there's no action:value separator", got)
+	}
+}
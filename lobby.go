@@ -0,0 +1,587 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/i4ki/ubt-server/pkg/proto"
+	"github.com/i4ki/ubt-server/pkg/sim"
+	"github.com/i4ki/ubt-server/pkg/store"
+)
+
+type (
+	roomID string
+
+	// Room is a single match: at most two players plus the FSM state that
+	// used to live directly on the server-wide GameState.
+	Room struct {
+		ID       roomID
+		Name     string
+		password string
+
+		players map[playerName]*Player
+		// IdleTimeout is how long a player can go without sending a frame
+		// before the idle watchdog kicks them and frees their slot.
+		IdleTimeout time.Duration
+
+		match     *sim.Match
+		matchOnce sync.Once
+		sync.Mutex
+
+		// spectators and spectatorsMu are kept separate from the players
+		// map and its own lock, since spectators fan out independently and
+		// shouldn't contend with the player/match bookkeeping above.
+		spectators   []*Spectator
+		spectatorsMu sync.Mutex
+
+		// store, startedAt, matchPlayers and moveLog feed recordMatch once
+		// the match concludes; they're only meaningful after ensureMatch has
+		// run.
+		store        store.Store
+		startedAt    time.Time
+		matchPlayers [2]store.MatchPlayer
+		moveLog      []store.MoveLogEntry
+	}
+
+	// Lobby holds every in-progress Room, keyed by ID, and matchmakes
+	// incoming connections into one of them.
+	Lobby struct {
+		rooms map[roomID]*Room
+		store store.Store
+		sync.Mutex
+	}
+
+	roomInfo struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		Players     int    `json:"players"`
+		HasPassword bool   `json:"has_password"`
+	}
+
+	lobbyActionMessage struct {
+		Action   string `json:"action"`
+		Player   string `json:"player,omitempty"`
+		Name     string `json:"name,omitempty"`
+		Password string `json:"password,omitempty"`
+		RoomID   string `json:"room_id,omitempty"`
+		// Count is the number of entries requested for actionLeaderboard.
+		Count int `json:"count,omitempty"`
+	}
+
+	lobbyMessage struct {
+		Status string     `json:"status"`
+		Code   StatusCode `json:"code"`
+		RoomID string     `json:"room_id,omitempty"`
+		Rooms  []roomInfo `json:"rooms,omitempty"`
+	}
+
+	// Spectator is a read-only observer attached to a Room: it receives the
+	// same sim.Snapshot broadcasts as the players, but anything it sends is
+	// discarded.
+	Spectator struct {
+		conn      net.Conn
+		Snapshots chan []byte
+	}
+)
+
+const (
+	actionListRooms  = "list_rooms"
+	actionCreateRoom = "create_room"
+	actionJoinRoom   = "join_room"
+	actionSpectate   = "spectate"
+)
+
+var (
+	errRoomNotFound = errors.New("room not found")
+	errRoomFull     = errors.New("room is full")
+	errBadPassword  = errors.New("wrong room password")
+)
+
+func newLobby(st store.Store) *Lobby {
+	return &Lobby{
+		rooms: make(map[roomID]*Room),
+		store: st,
+	}
+}
+
+func newRoomID() (roomID, error) {
+	buf := make([]byte, 4)
+
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return roomID(hex.EncodeToString(buf)), nil
+}
+
+// createRoom starts a new Room, wires it up with its own idle watchdog, and
+// registers it so it shows up in listRooms.
+func (l *Lobby) createRoom(name, password string, idleTimeout time.Duration) (*Room, error) {
+	id, err := newRoomID()
+
+	if err != nil {
+		return nil, err
+	}
+
+	room := &Room{
+		ID:          id,
+		Name:        name,
+		password:    password,
+		players:     make(map[playerName]*Player),
+		IdleTimeout: idleTimeout,
+		store:       l.store,
+	}
+
+	l.Lock()
+	l.rooms[room.ID] = room
+	l.Unlock()
+
+	go watchIdlePlayers(l, room)
+
+	return room, nil
+}
+
+// joinRoom looks up an existing, joinable room by ID, checking its password
+// and player count.
+func (l *Lobby) joinRoom(id roomID, password string) (*Room, error) {
+	l.Lock()
+	room, ok := l.rooms[id]
+	l.Unlock()
+
+	if !ok {
+		return nil, errRoomNotFound
+	}
+
+	room.Lock()
+	full := len(room.players) >= 2
+	badPassword := room.password != "" && room.password != password
+	room.Unlock()
+
+	if full {
+		return nil, errRoomFull
+	}
+
+	if badPassword {
+		return nil, errBadPassword
+	}
+
+	return room, nil
+}
+
+// findRoom looks up a room by ID with no join-eligibility checks, for
+// read-only observers that should be able to watch a match already in
+// progress, including a room with two players already seated.
+func (l *Lobby) findRoom(id roomID) (*Room, error) {
+	l.Lock()
+	room, ok := l.rooms[id]
+	l.Unlock()
+
+	if !ok {
+		return nil, errRoomNotFound
+	}
+
+	return room, nil
+}
+
+// destroyRoom removes a room from the lobby. Called once its last player
+// has left.
+func (l *Lobby) destroyRoom(id roomID) {
+	l.Lock()
+	delete(l.rooms, id)
+	l.Unlock()
+}
+
+// listRooms returns a snapshot of every room, full ones included, with its
+// player count and whether it has a password, for the connect handshake to
+// hand back to a client instead of silently rejecting it.
+func (l *Lobby) listRooms() []roomInfo {
+	l.Lock()
+	defer l.Unlock()
+
+	rooms := make([]roomInfo, 0, len(l.rooms))
+
+	for _, room := range l.rooms {
+		room.Lock()
+		rooms = append(rooms, roomInfo{
+			ID:          string(room.ID),
+			Name:        room.Name,
+			Players:     len(room.players),
+			HasPassword: room.password != "",
+		})
+		room.Unlock()
+	}
+
+	return rooms
+}
+
+func lobbyErrorCode(err error) StatusCode {
+	switch err {
+	case errRoomFull:
+		return ETooManyPlayers
+	case errBadPassword:
+		return EBadPassword
+	default:
+		return ERoomNotFound
+	}
+}
+
+// handleLobbyAction drives a connection through list_rooms/create_room/
+// join_room requests until it picks or creates a room to play in, returning
+// that room so the caller can hand the connection off to the FSM. A spectate
+// request is serviced in full here and returns a nil room.
+func handleLobbyAction(conn net.Conn, lobby *Lobby) (*Room, error) {
+	for {
+		buf, err := readMessage(conn)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var action lobbyActionMessage
+
+		if err := json.Unmarshal(buf, &action); err != nil {
+			return nil, err
+		}
+
+		switch action.Action {
+		case actionListRooms:
+			msg := lobbyMessage{
+				Status: "ok",
+				Code:   ESuccess,
+				Rooms:  lobby.listRooms(),
+			}
+
+			if err := sendMessage(conn, proto.Connect, msg); err != nil {
+				return nil, err
+			}
+		case actionCreateRoom:
+			room, err := lobby.createRoom(action.Name, action.Password, defaultIdleTimeout)
+
+			if err != nil {
+				return nil, err
+			}
+
+			msg := lobbyMessage{
+				Status: "room created",
+				Code:   ESuccess,
+				RoomID: string(room.ID),
+			}
+
+			if err := sendMessage(conn, proto.Connect, msg); err != nil {
+				return nil, err
+			}
+
+			return room, nil
+		case actionJoinRoom:
+			room, err := lobby.joinRoom(roomID(action.RoomID), action.Password)
+
+			if err != nil {
+				msg := lobbyMessage{Status: err.Error(), Code: lobbyErrorCode(err)}
+				sendMessage(conn, proto.Connect, msg)
+				continue
+			}
+
+			msg := lobbyMessage{
+				Status: "joined room",
+				Code:   ESuccess,
+				RoomID: string(room.ID),
+			}
+
+			if err := sendMessage(conn, proto.Connect, msg); err != nil {
+				return nil, err
+			}
+
+			return room, nil
+		case actionSpectate:
+			room, err := lobby.findRoom(roomID(action.RoomID))
+
+			if err != nil {
+				msg := lobbyMessage{Status: err.Error(), Code: lobbyErrorCode(err)}
+				sendMessage(conn, proto.Connect, msg)
+				continue
+			}
+
+			msg := lobbyMessage{
+				Status: "spectating",
+				Code:   ESuccess,
+				RoomID: string(room.ID),
+			}
+
+			if err := sendMessage(conn, proto.Connect, msg); err != nil {
+				return nil, err
+			}
+
+			spec := room.addSpectator(conn)
+			runSpectator(room, conn, spec)
+
+			return nil, nil
+		case actionStats:
+			if err := handleStatsAction(conn, lobby.store, action.Player); err != nil {
+				return nil, err
+			}
+		case actionLeaderboard:
+			if err := handleLeaderboardAction(conn, lobby.store, action.Count); err != nil {
+				return nil, err
+			}
+		default:
+			msg := lobbyMessage{
+				Status: fmt.Sprintf("unknown lobby action: %q", action.Action),
+				Code:   EInternal,
+			}
+
+			sendMessage(conn, proto.Connect, msg)
+		}
+	}
+}
+
+// leaveRoom evicts player from room and, if that leaves the room empty,
+// destroys it so it drops off the lobby listing.
+func leaveRoom(lobby *Lobby, room *Room, name playerName) {
+	room.Lock()
+
+	if player, ok := room.players[name]; ok {
+		// closeRoomSnapshotChans may already have closed it at match end.
+		select {
+		case _, open := <-player.Snapshots:
+			if open {
+				close(player.Snapshots)
+			}
+		default:
+			close(player.Snapshots)
+		}
+	}
+
+	delete(room.players, name)
+	empty := len(room.players) == 0
+	room.Unlock()
+
+	if empty {
+		lobby.destroyRoom(room.ID)
+		fmt.Printf("Room %s destroyed\n", room.ID)
+	}
+}
+
+// ensureMatch starts room's authoritative sim.Match the first time either
+// player reaches statePlay, then kicks off the room's tick loop. Safe to
+// call from both players' FSM goroutines; only the first call does anything.
+func (room *Room) ensureMatch() {
+	room.matchOnce.Do(func() {
+		room.Lock()
+
+		var names [2]string
+
+		for _, p := range room.players {
+			names[p.matchIndex] = p.Name
+			room.matchPlayers[p.matchIndex] = store.MatchPlayer{
+				Name:      p.Name,
+				Character: p.Character.String(),
+			}
+		}
+
+		room.match = sim.NewMatch(names[0], names[1], defaultRoundTimer)
+		room.startedAt = time.Now()
+
+		room.Unlock()
+
+		systemChat(room, "Round 1 begins")
+
+		go roomTick(room)
+	})
+}
+
+// roomTick runs room's fixed-rate simulation loop, applying queued inputs
+// each tick (via ApplyInput, called from the FSM goroutines under the same
+// lock) and broadcasting the resulting sim.Snapshot to both players. This
+// replaces the old 500ms key-echo polling with a real authoritative
+// simulation, eliminating its latency and the desync it allowed.
+func roomTick(room *Room) {
+	ticker := time.NewTicker(sim.TickInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		room.Lock()
+
+		snapshot := room.match.Tick(sim.TickInterval)
+
+		data, err := json.Marshal(snapshot)
+
+		if err != nil {
+			room.Unlock()
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+			continue
+		}
+
+		for _, p := range room.players {
+			postSnapshot(p.Snapshots, data)
+		}
+
+		// Snapshot moveLog while still holding room's lock: players keep
+		// sending KeyPress frames (and appending to it) right up until they
+		// observe snapshot.Over themselves, so reading it after Unlock
+		// would race.
+		var moves []store.MoveLogEntry
+
+		if snapshot.Over {
+			moves = append(moves, room.moveLog...)
+		}
+
+		room.Unlock()
+
+		room.spectatorsMu.Lock()
+		for _, s := range room.spectators {
+			postSnapshot(s.Snapshots, data)
+		}
+		room.spectatorsMu.Unlock()
+
+		if snapshot.Over {
+			recordMatch(room, snapshot, moves)
+			closeRoomSnapshotChans(room)
+			return
+		}
+	}
+}
+
+// closeRoomSnapshotChans closes every still-seated player's and spectator's
+// Snapshots channel once a match has ended, so their relay loops
+// (relaySnapshots, runSpectator) return instead of ranging over a channel
+// nothing will ever post to again.
+func closeRoomSnapshotChans(room *Room) {
+	room.Lock()
+	for _, p := range room.players {
+		close(p.Snapshots)
+	}
+	room.Unlock()
+
+	room.spectatorsMu.Lock()
+	for _, s := range room.spectators {
+		close(s.Snapshots)
+	}
+	room.spectatorsMu.Unlock()
+}
+
+// addSpectator registers conn as a read-only observer of room.
+func (room *Room) addSpectator(conn net.Conn) *Spectator {
+	spec := &Spectator{conn: conn, Snapshots: make(chan []byte, 1)}
+
+	room.spectatorsMu.Lock()
+	room.spectators = append(room.spectators, spec)
+	room.spectatorsMu.Unlock()
+
+	return spec
+}
+
+// removeSpectator drops spec from room's fan-out list.
+func (room *Room) removeSpectator(spec *Spectator) {
+	room.spectatorsMu.Lock()
+	defer room.spectatorsMu.Unlock()
+
+	for i, s := range room.spectators {
+		if s == spec {
+			room.spectators = append(room.spectators[:i], room.spectators[i+1:]...)
+			return
+		}
+	}
+}
+
+// runSpectator relays room's sim.Snapshot broadcasts to conn until it
+// disconnects or a write fails. Anything the spectator sends is read and
+// discarded by discardInput, rather than being mis-routed into the FSM the
+// way a third connection used to be.
+func runSpectator(room *Room, conn net.Conn, spec *Spectator) {
+	defer room.removeSpectator(spec)
+
+	go discardInput(conn)
+
+	for snapshot := range spec.Snapshots {
+		if err := sendMessage(conn, proto.Sync, json.RawMessage(snapshot)); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+			return
+		}
+	}
+}
+
+// discardInput keeps reading frames from a spectator connection and
+// throwing them away, so a spectator's input can never affect the match.
+func discardInput(conn net.Conn) {
+	for {
+		if _, err := proto.ReadPacket(conn); err != nil {
+			return
+		}
+	}
+}
+
+// postSnapshot delivers data to ch, keeping only the most recent snapshot if
+// the reader hasn't drained the previous one yet.
+func postSnapshot(ch chan []byte, data []byte) {
+	select {
+	case ch <- data:
+	default:
+		select {
+		case <-ch:
+		default:
+		}
+
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
+
+// watchIdlePlayers periodically evicts players that have gone quiet for
+// longer than room.IdleTimeout, sending them a Kick status and freeing their
+// slot. Before this, a client that crashed mid-game left the room stuck at
+// 2 players forever. If kicking empties the room, it's destroyed the same
+// way leaveRoom destroys one a departing player empties, and this goroutine
+// returns instead of ticking forever over a room nobody can reach anymore.
+func watchIdlePlayers(lobby *Lobby, room *Room) {
+	ticker := time.NewTicker(room.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		room.Lock()
+
+		for name, player := range room.players {
+			lastActivity := time.Unix(0, atomic.LoadInt64(&player.LastActivity))
+
+			if time.Since(lastActivity) < room.IdleTimeout {
+				continue
+			}
+
+			fmt.Printf("Kicking idle player: %s\n", player.Name)
+
+			kickMsg := kickMessage{
+				Status: "kicked for inactivity",
+				Code:   EKicked,
+				Reason: fmt.Sprintf("no activity for %s", room.IdleTimeout),
+			}
+
+			if err := sendMessage(player.conn, proto.Kick, kickMsg); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+			}
+
+			player.conn.Close()
+			close(player.Snapshots)
+
+			delete(room.players, name)
+		}
+
+		empty := len(room.players) == 0
+
+		room.Unlock()
+
+		if empty {
+			lobby.destroyRoom(room.ID)
+			fmt.Printf("Room %s destroyed (idle)\n", room.ID)
+			return
+		}
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/i4ki/ubt-server/pkg/proto"
+	"github.com/i4ki/ubt-server/pkg/store"
 )
 
 type (
@@ -19,17 +22,30 @@ type (
 
 	Player struct {
 		Name      string    `json:"name"`
-		ipaddr    string    `json:"ipaddr,omitempty"`
+		IPAddr    string    `json:"ipaddr,omitempty"`
 		Character Character `json:"character,omitempty"`
-		Left      bool      `json:"left",omitempty"`
-		Keys      chan string
+		Left      bool      `json:"left,omitempty"`
 
 		state State
-	}
-
-	GameState struct {
-		players map[playerName]*Player
-		sync.Mutex
+		conn  net.Conn
+		// LastActivity is unix nanoseconds, written by touch without
+		// holding room's lock and read by watchIdlePlayers from a different
+		// goroutine, so it's updated atomically rather than as a plain
+		// time.Time.
+		LastActivity int64
+
+		// matchIndex is this player's slot (0 or 1) in the room's sim.Match.
+		matchIndex int
+		// Snapshots carries each tick's sim.Snapshot JSON for relaySnapshots
+		// to forward to this player's connection, replacing the old raw key
+		// echo. Closed once this player stops being relevant to a match.
+		Snapshots chan []byte
+	}
+
+	kickMessage struct {
+		Status string     `json:"status"`
+		Code   StatusCode `json:"code"`
+		Reason string     `json:"reason"`
 	}
 
 	statusMessage struct {
@@ -49,11 +65,6 @@ type (
 		Code   StatusCode `json:"code"`
 		Left   bool       `json:"left"`
 	}
-
-	getInfoMessage struct {
-		Player playerName `json:"player"`
-		Action string     `json:"action"`
-	}
 )
 
 const (
@@ -74,8 +85,19 @@ const (
 	ESelect
 	ENotReady
 	EInternal
+	EKicked
+	ERoomNotFound
+	EBadPassword
 )
 
+// defaultIdleTimeout is how long a player can stay quiet (no key frames,
+// never finishing stateSelect, etc.) before the idle watchdog evicts them.
+const defaultIdleTimeout = 60 * time.Second
+
+// defaultRoundTimer is how long a match's round clock runs before the sim
+// decides the round on remaining HP.
+const defaultRoundTimer = 99 * time.Second
+
 func (c Character) String() string {
 	if c == Max {
 		return "max"
@@ -100,11 +122,17 @@ func main() {
 
 	fmt.Println("Listening on :5000")
 
-	game := GameState{
-		Mutex:   sync.Mutex{},
-		players: make(map[playerName]*Player),
+	st, err := store.NewSQLiteStore("ubt-server.db")
+
+	if err != nil {
+		fmt.Println("Error opening store:", err.Error())
+		os.Exit(1)
 	}
 
+	defer st.Close()
+
+	lobby := newLobby(st)
+
 	for {
 		// Listen for an incoming connection.
 		conn, err := l.Accept()
@@ -113,10 +141,15 @@ func main() {
 			os.Exit(1)
 		}
 
-		go handleRequest(conn, &game)
+		go handleRequest(conn, lobby)
 	}
 }
 
+// touch records activity from player, resetting its idle watchdog countdown.
+func touch(player *Player) {
+	atomic.StoreInt64(&player.LastActivity, time.Now().UnixNano())
+}
+
 func toManyPlayers(conn net.Conn) {
 	errMsg := statusMessage{
 		Status: "Too many players in the room",
@@ -130,17 +163,17 @@ func toManyPlayers(conn net.Conn) {
 		return
 	}
 
-	conn.Write(msg)
+	proto.WritePacket(conn, proto.Status, msg)
 }
 
-func sendMessage(conn net.Conn, message interface{}) error {
+func sendMessage(conn net.Conn, typ proto.FrameType, message interface{}) error {
 	data, err := json.Marshal(&message)
 
 	if err != nil {
 		return err
 	}
 
-	_, err = conn.Write(append(data, '\n'))
+	err = proto.WritePacket(conn, typ, data)
 
 	fmt.Printf("Sent message: %s\n", string(data))
 
@@ -153,7 +186,7 @@ func sendSuccess(conn net.Conn) error {
 		Code:   ESuccess,
 	}
 
-	return sendMessage(conn, connSuccess)
+	return sendMessage(conn, proto.Status, connSuccess)
 }
 
 func sendNotReady(conn net.Conn) error {
@@ -162,7 +195,7 @@ func sendNotReady(conn net.Conn) error {
 		Code:   ENotReady,
 	}
 
-	return sendMessage(conn, notReady)
+	return sendMessage(conn, proto.Status, notReady)
 }
 
 func sendInternalError(conn net.Conn) error {
@@ -171,7 +204,7 @@ func sendInternalError(conn net.Conn) error {
 		Code:   EInternal,
 	}
 
-	return sendMessage(conn, errInternal)
+	return sendMessage(conn, proto.Status, errInternal)
 }
 
 func sendConnectSuccess(conn net.Conn, left bool) error {
@@ -181,7 +214,7 @@ func sendConnectSuccess(conn net.Conn, left bool) error {
 		Left:   left,
 	}
 
-	return sendMessage(conn, connMessage)
+	return sendMessage(conn, proto.Connect, connMessage)
 }
 
 func sendSelectedPlayer(conn net.Conn, player *Player) error {
@@ -192,21 +225,23 @@ func sendSelectedPlayer(conn net.Conn, player *Player) error {
 		Code:      ESuccess,
 	}
 
-	return sendMessage(conn, selectedMsg)
+	return sendMessage(conn, proto.SelectChar, selectedMsg)
 }
 
+// readMessage reads a single framed message and returns its raw payload,
+// regardless of frame type. Because proto.ReadPacket blocks for the full
+// length-prefixed frame, this no longer risks a partial read or coalescing
+// two messages into one buffer the way a bare conn.Read(buf[:1024]) did.
 func readMessage(conn net.Conn) ([]byte, error) {
-	buf := make([]byte, 1024)
-
-	n, err := conn.Read(buf)
+	pkt, err := proto.ReadPacket(conn)
 
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("Received: %s\n", string(buf[:n]))
+	fmt.Printf("Received: %s\n", string(pkt.Payload))
 
-	return buf[:n], nil
+	return pkt.Payload, nil
 }
 
 func getPlayer(conn net.Conn) (Player, error) {
@@ -219,7 +254,7 @@ func getPlayer(conn net.Conn) (Player, error) {
 	var player Player
 	err = json.Unmarshal(buf, &player)
 
-	player.Keys = make(chan string, 16)
+	player.Snapshots = make(chan []byte, 1)
 
 	if err != nil {
 		return Player{}, err
@@ -229,18 +264,14 @@ func getPlayer(conn net.Conn) (Player, error) {
 }
 
 func getPlayerCharSelect(conn net.Conn) (Character, error) {
-	buf := make([]byte, 1024)
-
-	n, err := conn.Read(buf)
+	buf, err := readMessage(conn)
 
 	if err != nil {
 		return 0, err
 	}
 
-	fmt.Printf("Received: %s\n", string(buf[:n]))
-
 	var message selectMessage
-	err = json.Unmarshal(buf[:n], &message)
+	err = json.Unmarshal(buf, &message)
 
 	if err != nil {
 		return 0, err
@@ -259,103 +290,59 @@ func getPlayerCharSelect(conn net.Conn) (Character, error) {
 	return 0, errors.New("invalid character choice")
 }
 
-func sendOtherPlayerKey(conn net.Conn, key string) error {
-	dataStr := `{"key": "` + key + `"}`
+func handleRequest(conn net.Conn, lobby *Lobby) {
+	fmt.Printf("Connection established from %s\n", conn.RemoteAddr())
 
-	fmt.Printf("Sending info: %s\n", dataStr)
+	defer conn.Close()
 
-	_, err := conn.Write([]byte(dataStr + "\n"))
+	room, err := handleLobbyAction(conn, lobby)
 
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+		return
 	}
 
-	return nil
-}
-
-func getPlayerKey(conn net.Conn) (string, error) {
-	data, err := readMessage(conn)
-
-	if err != nil {
-		return "", err
+	if room == nil {
+		// The lobby action already fully serviced this connection (e.g.
+		// actionSpectate ran its own loop), so there's no room to seat a
+		// player into.
+		return
 	}
 
-	var info map[string]string
+	handleRoomConnection(conn, lobby, room)
+}
 
-	err = json.Unmarshal(data, &info)
+// handleRoomConnection seats a brand new player in room. The blocking read
+// of the player's details happens before room's lock is taken, so a slow or
+// stalled joiner can't hold room's lock hostage.
+func handleRoomConnection(conn net.Conn, lobby *Lobby, room *Room) {
+	player, err := getPlayer(conn)
 
 	if err != nil {
-		return "", err
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+		return
 	}
 
-	return info["key"], nil
-}
-
-func handleRequest(conn net.Conn, game *GameState) {
-	fmt.Printf("Connection established from %s\n", conn.RemoteAddr())
-
-	defer conn.Close()
-
-	game.Lock()
-
-	if len(game.players) == 2 {
-		game.Unlock()
-
-		data, err := readMessage(conn)
-
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
-			return
-		}
-
-		var dataObj getInfoMessage
-
-		err = json.Unmarshal(data, &dataObj)
-
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
-			return
-		}
-
-		player, ok := game.players[dataObj.Player]
-
-		if !ok {
-			fmt.Fprintf(os.Stderr, "ERROR: Player not found\n")
-			return
-		}
-
-		game.Lock()
-
-		var otherPlayer *Player
-
-		for _, p := range game.players {
-			if p == player {
-				continue
-			}
+	player.state = stateSelect
+	player.conn = conn
+	touch(&player)
 
-			otherPlayer = p
-		}
+	room.Lock()
 
-		game.Unlock()
+	if len(room.players) == 2 {
+		room.Unlock()
 
-		runFSM(game, otherPlayer, player, conn, true)
+		toManyPlayers(conn)
 		return
 	}
 
-	player, err := getPlayer(conn)
-
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
-		return
-	}
+	player.matchIndex = len(room.players)
 
-	player.state = stateSelect
+	fmt.Printf("Player connected: %s (room %s)\n", player.Name, room.ID)
 
-	fmt.Printf("Player connected: %s\n", player.Name)
+	room.players[playerName(player.Name)] = &player
 
-	game.players[playerName(player.Name)] = &player
-
-	if len(game.players) == 1 {
+	if len(room.players) == 1 {
 		player.Left = true
 	} else {
 		player.Left = false
@@ -364,16 +351,33 @@ func handleRequest(conn net.Conn, game *GameState) {
 	err = sendConnectSuccess(conn, player.Left)
 
 	if err != nil {
+		room.Unlock()
+
 		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
 		return
 	}
 
-	game.Unlock()
+	room.Unlock()
+
+	defer leaveRoom(lobby, room, playerName(player.Name))
 
-	runFSM(game, &player, nil, conn, false)
+	runFSM(room, &player, nil, conn)
+}
+
+// relaySnapshots forwards room's tick broadcasts to conn as Sync frames
+// until player.Snapshots is closed, i.e. the match has ended or player has
+// left. Runs alongside runFSM's blocking read loop so delivering a snapshot
+// never has to wait on the player's next input frame, and vice versa.
+func relaySnapshots(conn net.Conn, player *Player) {
+	for snapshot := range player.Snapshots {
+		if err := sendMessage(conn, proto.Sync, json.RawMessage(snapshot)); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+			return
+		}
+	}
 }
 
-func runFSM(game *GameState, player *Player, otherPlayer *Player, conn net.Conn, remote bool) {
+func runFSM(room *Room, player *Player, otherPlayer *Player, conn net.Conn) {
 	for {
 		switch player.state {
 		case stateSelect:
@@ -387,7 +391,7 @@ func runFSM(game *GameState, player *Player, otherPlayer *Player, conn net.Conn,
 					Code:   ESelect,
 				}
 
-				err = sendMessage(conn, errMessage)
+				err = sendMessage(conn, proto.Status, errMessage)
 
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
@@ -395,6 +399,11 @@ func runFSM(game *GameState, player *Player, otherPlayer *Player, conn net.Conn,
 			}
 
 			player.Character = char
+			touch(player)
+
+			if err == nil {
+				systemChat(room, fmt.Sprintf("%s selected %s", player.Name, char.String()))
+			}
 
 			err = sendSuccess(conn)
 
@@ -417,17 +426,19 @@ func runFSM(game *GameState, player *Player, otherPlayer *Player, conn net.Conn,
 
 			fmt.Printf("Got message: %s\n", content)
 
-			game.Lock()
+			touch(player)
+
+			room.Lock()
 
-			if len(game.players) < 2 {
-				game.Unlock()
+			if len(room.players) < 2 {
+				room.Unlock()
 
 				sendNotReady(conn)
 
 				continue
 			}
 
-			for _, p := range game.players {
+			for _, p := range room.players {
 				if p == player {
 					continue
 				}
@@ -436,7 +447,7 @@ func runFSM(game *GameState, player *Player, otherPlayer *Player, conn net.Conn,
 			}
 
 			if otherPlayer.Character == 0 {
-				game.Unlock()
+				room.Unlock()
 
 				sendNotReady(conn)
 				continue
@@ -445,47 +456,48 @@ func runFSM(game *GameState, player *Player, otherPlayer *Player, conn net.Conn,
 			err = sendSelectedPlayer(conn, otherPlayer)
 
 			if err != nil {
-				game.Unlock()
+				room.Unlock()
 
 				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
 				continue
 			}
 
-			game.Unlock()
+			room.Unlock()
 
+			room.ensureMatch()
+			go relaySnapshots(conn, player)
 			player.state = statePlay
 		case stateSync: // validate players and then enter the game
 			//sendStartPlay(conn
 		case statePlay:
-			if remote {
-				var err error
-
-				select {
-				case key := <-otherPlayer.Keys:
-					err = sendOtherPlayerKey(conn, key)
-				default:
-					err = sendOtherPlayerKey(conn, "")
-					time.Sleep(time.Millisecond * 500)
-				}
+			pkt, err := proto.ReadPacket(conn)
 
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
-					return
-				}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
+				return
+			}
 
-			} else {
-				key, err := getPlayerKey(conn)
+			touch(player)
 
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "ERROR: %s\n", err.Error())
-					return
-				}
+			switch pkt.Type {
+			case proto.Chat:
+				handleChatFrame(room, player, pkt.Payload)
+			case proto.KeyPress:
+				key := string(pkt.Payload)
 
-				player.Keys <- key
+				room.Lock()
+				room.match.ApplyInput(player.matchIndex, key)
+				room.moveLog = append(room.moveLog, store.MoveLogEntry{
+					Player: player.Name,
+					Key:    key,
+					At:     time.Now(),
+				})
+				room.Unlock()
 
 				fmt.Printf("Player %s pressed key %s\n", player.Name, key)
+			default:
+				fmt.Fprintf(os.Stderr, "ERROR: unexpected frame type in statePlay: %v\n", pkt.Type)
 			}
-
 		default:
 			fmt.Printf("Wrong state: %v\n", player.state)
 		}
@@ -0,0 +1,102 @@
+// Package proto implements the length-prefixed framing used between the
+// ubt-server and its clients, replacing the old behaviour of reading a
+// fixed-size buffer and hoping it held exactly one JSON message.
+package proto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// FrameType identifies the kind of payload carried by a frame.
+type FrameType byte
+
+const (
+	Connect FrameType = iota + 1
+	SelectChar
+	Sync
+	KeyPress
+	Status
+	Kick
+	Chat
+)
+
+const (
+	// lenSize is the width of the length prefix, in bytes.
+	lenSize = 2
+	// typeSize is the width of the frame type field, in bytes.
+	typeSize = 1
+	// headerSize is lenSize+typeSize.
+	headerSize = lenSize + typeSize
+	// MaxPayloadSize is the largest payload a 2-byte length prefix can carry.
+	MaxPayloadSize = 1<<16 - 1
+)
+
+// ErrPayloadTooLarge is returned by WritePacket when payload doesn't
+// fit in the 2-byte length prefix.
+var ErrPayloadTooLarge = errors.New("proto: payload too large")
+
+// Packet is a single decoded frame: a type and its payload.
+type Packet struct {
+	Type    FrameType
+	Payload []byte
+}
+
+// ReadPacket reads exactly one frame from r, blocking until the full header
+// and payload have arrived. Unlike a raw conn.Read, it never returns a
+// partial frame or coalesces two frames into one.
+func ReadPacket(r io.Reader) (*Packet, error) {
+	header := make([]byte, headerSize)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint16(header[:lenSize])
+	typ := FrameType(header[lenSize])
+
+	payload := make([]byte, length)
+
+	if length > 0 {
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Packet{Type: typ, Payload: payload}, nil
+}
+
+// WritePacket writes a single frame to w: a 2-byte length prefix, a 1-byte
+// frame type, then the payload.
+func WritePacket(w io.Writer, typ FrameType, payload []byte) error {
+	if len(payload) > MaxPayloadSize {
+		return ErrPayloadTooLarge
+	}
+
+	buf := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint16(buf[:lenSize], uint16(len(payload)))
+	buf[lenSize] = byte(typ)
+	copy(buf[headerSize:], payload)
+
+	_, err := w.Write(buf)
+
+	return err
+}
+
+// ReadString reads one frame and returns its payload decoded as a string,
+// along with the frame type so callers can validate it's what they expected.
+func ReadString(r io.Reader) (string, FrameType, error) {
+	pkt, err := ReadPacket(r)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	return string(pkt.Payload), pkt.Type, nil
+}
+
+// WriteString writes a frame whose payload is the raw bytes of s.
+func WriteString(w io.Writer, typ FrameType, s string) error {
+	return WritePacket(w, typ, []byte(s))
+}
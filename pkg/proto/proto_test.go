@@ -0,0 +1,105 @@
+package proto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWritePacketReadPacketRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := WritePacket(&buf, Chat, []byte("hello")); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	pkt, err := ReadPacket(&buf)
+
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+
+	if pkt.Type != Chat {
+		t.Errorf("Type = %v, want %v", pkt.Type, Chat)
+	}
+
+	if string(pkt.Payload) != "hello" {
+		t.Errorf("Payload = %q, want %q", pkt.Payload, "hello")
+	}
+}
+
+func TestWritePacketEmptyPayload(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := WritePacket(&buf, Status, nil); err != nil {
+		t.Fatalf("WritePacket: %v", err)
+	}
+
+	pkt, err := ReadPacket(&buf)
+
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+
+	if len(pkt.Payload) != 0 {
+		t.Errorf("Payload = %q, want empty", pkt.Payload)
+	}
+}
+
+func TestWritePacketTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := WritePacket(&buf, Status, make([]byte, MaxPayloadSize+1))
+
+	if err != ErrPayloadTooLarge {
+		t.Errorf("err = %v, want %v", err, ErrPayloadTooLarge)
+	}
+}
+
+func TestReadPacketMultipleFrames(t *testing.T) {
+	var buf bytes.Buffer
+
+	WritePacket(&buf, KeyPress, []byte("left"))
+	WritePacket(&buf, KeyPress, []byte("right"))
+
+	first, err := ReadPacket(&buf)
+
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+
+	if string(first.Payload) != "left" {
+		t.Errorf("first payload = %q, want %q", first.Payload, "left")
+	}
+
+	second, err := ReadPacket(&buf)
+
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+
+	if string(second.Payload) != "right" {
+		t.Errorf("second payload = %q, want %q", second.Payload, "right")
+	}
+}
+
+func TestWriteStringReadString(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := WriteString(&buf, Connect, "ready"); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+
+	s, typ, err := ReadString(&buf)
+
+	if err != nil {
+		t.Fatalf("ReadString: %v", err)
+	}
+
+	if typ != Connect {
+		t.Errorf("typ = %v, want %v", typ, Connect)
+	}
+
+	if s != "ready" {
+		t.Errorf("s = %q, want %q", s, "ready")
+	}
+}
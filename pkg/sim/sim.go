@@ -0,0 +1,164 @@
+// Package sim owns the authoritative fight simulation: fighter positions,
+// HP, and combat resolution. The server runs it at a fixed tick and
+// broadcasts the result to both clients, which send only input intents.
+package sim
+
+import "time"
+
+const (
+	// TickRate is how many times per second a Match advances, the high end
+	// of the 30-60Hz range fighting games commonly simulate combat at.
+	TickRate = 60
+	// TickInterval is the fixed-timestep duration of a single Tick.
+	TickInterval = time.Second / TickRate
+
+	startHP      = 100
+	moveSpeed    = 4.0 // arena units per second
+	attackRange  = 1.5
+	attackDamage = 8
+	arenaWidth   = 20.0
+)
+
+// Vec2 is a 2D position in arena units.
+type Vec2 struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Fighter is one combatant's live state inside a Match.
+type Fighter struct {
+	Name   string `json:"name"`
+	Pos    Vec2   `json:"pos"`
+	HP     int    `json:"hp"`
+	Facing int    `json:"facing"` // 1 = right, -1 = left
+	Anim   string `json:"anim"`
+}
+
+// Snapshot is the authoritative state broadcast to both clients every tick.
+type Snapshot struct {
+	Fighters   [2]Fighter    `json:"fighters"`
+	RoundTimer time.Duration `json:"round_timer"`
+	Over       bool          `json:"over"`
+	Winner     string        `json:"winner,omitempty"`
+}
+
+// Match owns the authoritative state for one room's fight and resolves
+// combat from queued input intents, one fixed Tick at a time.
+type Match struct {
+	fighters   [2]Fighter
+	roundTimer time.Duration
+}
+
+// NewMatch starts a fresh match between two named fighters, placed at
+// opposite ends of the arena.
+func NewMatch(p1Name, p2Name string, roundTimer time.Duration) *Match {
+	return &Match{
+		fighters: [2]Fighter{
+			{Name: p1Name, Pos: Vec2{X: 2}, HP: startHP, Facing: 1, Anim: "idle"},
+			{Name: p2Name, Pos: Vec2{X: arenaWidth - 2}, HP: startHP, Facing: -1, Anim: "idle"},
+		},
+		roundTimer: roundTimer,
+	}
+}
+
+// ApplyInput resolves one key intent from player (0 or 1) against the
+// current state: movement, an attack, or idle.
+func (m *Match) ApplyInput(player int, key string) {
+	if player < 0 || player > 1 {
+		return
+	}
+
+	f := &m.fighters[player]
+
+	if f.HP <= 0 {
+		return
+	}
+
+	switch key {
+	case "left":
+		f.Pos.X -= moveSpeed * TickInterval.Seconds()
+		f.Anim = "walk"
+	case "right":
+		f.Pos.X += moveSpeed * TickInterval.Seconds()
+		f.Anim = "walk"
+	case "punch":
+		f.Anim = "punch"
+		m.resolveAttack(player)
+	default:
+		f.Anim = "idle"
+	}
+
+	if f.Pos.X < 0 {
+		f.Pos.X = 0
+	}
+
+	if f.Pos.X > arenaWidth {
+		f.Pos.X = arenaWidth
+	}
+}
+
+func (m *Match) resolveAttack(attacker int) {
+	defender := 1 - attacker
+	a, d := &m.fighters[attacker], &m.fighters[defender]
+
+	dist := a.Pos.X - d.Pos.X
+
+	if dist < 0 {
+		dist = -dist
+	}
+
+	if dist > attackRange || d.HP <= 0 {
+		return
+	}
+
+	d.HP -= attackDamage
+	d.Anim = "hit"
+
+	if d.HP < 0 {
+		d.HP = 0
+	}
+}
+
+// Tick advances the round timer by dt and returns the current Snapshot.
+func (m *Match) Tick(dt time.Duration) Snapshot {
+	if m.roundTimer > 0 {
+		m.roundTimer -= dt
+
+		if m.roundTimer < 0 {
+			m.roundTimer = 0
+		}
+	}
+
+	over, winner := m.outcome()
+
+	return Snapshot{
+		Fighters:   m.fighters,
+		RoundTimer: m.roundTimer,
+		Over:       over,
+		Winner:     winner,
+	}
+}
+
+func (m *Match) outcome() (bool, string) {
+	p1, p2 := m.fighters[0], m.fighters[1]
+
+	switch {
+	case p1.HP <= 0 && p2.HP <= 0:
+		return true, ""
+	case p1.HP <= 0:
+		return true, p2.Name
+	case p2.HP <= 0:
+		return true, p1.Name
+	case m.roundTimer <= 0:
+		switch {
+		case p1.HP == p2.HP:
+			return true, ""
+		case p1.HP > p2.HP:
+			return true, p1.Name
+		default:
+			return true, p2.Name
+		}
+	default:
+		return false, ""
+	}
+}
@@ -0,0 +1,135 @@
+package sim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewMatchInitialState(t *testing.T) {
+	m := NewMatch("alice", "bob", time.Second)
+	snap := m.Tick(0)
+
+	if snap.Fighters[0].Name != "alice" || snap.Fighters[1].Name != "bob" {
+		t.Fatalf("fighters = %+v, want alice/bob", snap.Fighters)
+	}
+
+	if snap.Fighters[0].HP != startHP || snap.Fighters[1].HP != startHP {
+		t.Errorf("HP = %d/%d, want %d/%d", snap.Fighters[0].HP, snap.Fighters[1].HP, startHP, startHP)
+	}
+
+	if snap.Over {
+		t.Errorf("Over = true, want false for a fresh match")
+	}
+}
+
+func TestApplyInputMovementClampsToArena(t *testing.T) {
+	m := NewMatch("alice", "bob", time.Second)
+
+	for i := 0; i < 1000; i++ {
+		m.ApplyInput(0, "left")
+	}
+
+	snap := m.Tick(0)
+
+	if snap.Fighters[0].Pos.X != 0 {
+		t.Errorf("Pos.X = %v, want 0 (clamped)", snap.Fighters[0].Pos.X)
+	}
+
+	for i := 0; i < 1000; i++ {
+		m.ApplyInput(1, "right")
+	}
+
+	snap = m.Tick(0)
+
+	if snap.Fighters[1].Pos.X != arenaWidth {
+		t.Errorf("Pos.X = %v, want %v (clamped)", snap.Fighters[1].Pos.X, arenaWidth)
+	}
+}
+
+// closeToRange walks fighter 1 toward fighter 0 one input at a time until
+// they're within attackRange, for tests that need a punch to land.
+func closeToRange(t *testing.T, m *Match) {
+	t.Helper()
+
+	for i := 0; i < 10000; i++ {
+		gap := m.fighters[0].Pos.X - m.fighters[1].Pos.X
+
+		if gap < 0 {
+			gap = -gap
+		}
+
+		if gap <= attackRange {
+			return
+		}
+
+		if m.fighters[1].Pos.X > m.fighters[0].Pos.X {
+			m.ApplyInput(1, "left")
+		} else {
+			m.ApplyInput(1, "right")
+		}
+	}
+
+	t.Fatal("could not close the gap between fighters")
+}
+
+func TestApplyInputPunchInRangeDealsDamage(t *testing.T) {
+	m := NewMatch("alice", "bob", time.Second)
+
+	closeToRange(t, m)
+
+	m.ApplyInput(0, "punch")
+
+	snap := m.Tick(0)
+
+	if snap.Fighters[1].HP != startHP-attackDamage {
+		t.Errorf("defender HP = %d, want %d", snap.Fighters[1].HP, startHP-attackDamage)
+	}
+}
+
+func TestApplyInputPunchOutOfRangeMisses(t *testing.T) {
+	m := NewMatch("alice", "bob", time.Second)
+
+	m.ApplyInput(0, "punch")
+
+	snap := m.Tick(0)
+
+	if snap.Fighters[1].HP != startHP {
+		t.Errorf("defender HP = %d, want unchanged %d", snap.Fighters[1].HP, startHP)
+	}
+}
+
+func TestTickEndsMatchOnKO(t *testing.T) {
+	m := NewMatch("alice", "bob", time.Second)
+
+	closeToRange(t, m)
+
+	for m.fighters[1].HP > 0 {
+		m.ApplyInput(0, "punch")
+	}
+
+	snap := m.Tick(0)
+
+	if !snap.Over {
+		t.Fatalf("Over = false, want true once a fighter is KO'd")
+	}
+
+	if snap.Winner != "alice" {
+		t.Errorf("Winner = %q, want %q", snap.Winner, "alice")
+	}
+}
+
+func TestTickEndsMatchOnTimerExpiryByHP(t *testing.T) {
+	m := NewMatch("alice", "bob", time.Second)
+
+	m.ApplyInput(0, "punch") // out of range, no damage; just advances anim
+
+	snap := m.Tick(2 * time.Second)
+
+	if !snap.Over {
+		t.Fatalf("Over = false, want true once the round timer expires")
+	}
+
+	if snap.Winner != "" {
+		t.Errorf("Winner = %q, want draw on equal HP", snap.Winner)
+	}
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore is an in-memory Store, used in place of SQLiteStore in tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	players map[string]*PlayerRecord
+	matches []MatchResult
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{players: make(map[string]*PlayerRecord)}
+}
+
+func (s *MemoryStore) RecordMatch(result MatchResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.matches = append(s.matches, result)
+
+	for _, p := range result.Players {
+		rec, ok := s.players[p.Name]
+
+		if !ok {
+			rec = &PlayerRecord{Name: p.Name, CharacterUsage: make(map[string]int)}
+			s.players[p.Name] = rec
+		}
+
+		rec.MatchCount++
+		rec.TotalDuration += result.Duration
+		rec.CharacterUsage[p.Character]++
+
+		switch result.Winner {
+		case "":
+		case p.Name:
+			rec.Wins++
+		default:
+			rec.Losses++
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) LoadPlayer(name string) (PlayerRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.players[name]
+
+	if !ok {
+		return PlayerRecord{Name: name, CharacterUsage: make(map[string]int)}, nil
+	}
+
+	return *rec, nil
+}
+
+func (s *MemoryStore) Leaderboard(n int) ([]PlayerRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]PlayerRecord, 0, len(s.players))
+
+	for _, rec := range s.players {
+		records = append(records, *rec)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Wins > records[j].Wins
+	})
+
+	if n > 0 && n < len(records) {
+		records = records[:n]
+	}
+
+	return records, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreRecordMatchAndLoadPlayer(t *testing.T) {
+	s := NewMemoryStore()
+
+	err := s.RecordMatch(MatchResult{
+		RoomID: "room1",
+		Winner: "alice",
+		Players: [2]MatchPlayer{
+			{Name: "alice", Character: "max"},
+			{Name: "bob", Character: "drax"},
+		},
+		Duration: 30 * time.Second,
+	})
+
+	if err != nil {
+		t.Fatalf("RecordMatch: %v", err)
+	}
+
+	alice, err := s.LoadPlayer("alice")
+
+	if err != nil {
+		t.Fatalf("LoadPlayer: %v", err)
+	}
+
+	if alice.Wins != 1 || alice.Losses != 0 {
+		t.Errorf("alice wins/losses = %d/%d, want 1/0", alice.Wins, alice.Losses)
+	}
+
+	if alice.CharacterUsage["max"] != 1 {
+		t.Errorf("alice character usage[max] = %d, want 1", alice.CharacterUsage["max"])
+	}
+
+	bob, err := s.LoadPlayer("bob")
+
+	if err != nil {
+		t.Fatalf("LoadPlayer: %v", err)
+	}
+
+	if bob.Wins != 0 || bob.Losses != 1 {
+		t.Errorf("bob wins/losses = %d/%d, want 0/1", bob.Wins, bob.Losses)
+	}
+}
+
+func TestMemoryStoreLoadPlayerUnknown(t *testing.T) {
+	s := NewMemoryStore()
+
+	rec, err := s.LoadPlayer("nobody")
+
+	if err != nil {
+		t.Fatalf("LoadPlayer: %v", err)
+	}
+
+	if rec.MatchCount != 0 {
+		t.Errorf("MatchCount = %d, want 0 for an unknown player", rec.MatchCount)
+	}
+}
+
+func TestMemoryStoreLeaderboardOrdersByWins(t *testing.T) {
+	s := NewMemoryStore()
+
+	s.RecordMatch(MatchResult{
+		Winner: "alice",
+		Players: [2]MatchPlayer{
+			{Name: "alice", Character: "max"},
+			{Name: "bob", Character: "drax"},
+		},
+	})
+	s.RecordMatch(MatchResult{
+		Winner: "alice",
+		Players: [2]MatchPlayer{
+			{Name: "alice", Character: "max"},
+			{Name: "carol", Character: "drax"},
+		},
+	})
+	s.RecordMatch(MatchResult{
+		Winner: "bob",
+		Players: [2]MatchPlayer{
+			{Name: "bob", Character: "drax"},
+			{Name: "carol", Character: "max"},
+		},
+	})
+
+	ranked, err := s.Leaderboard(2)
+
+	if err != nil {
+		t.Fatalf("Leaderboard: %v", err)
+	}
+
+	if len(ranked) != 2 {
+		t.Fatalf("len(ranked) = %d, want 2", len(ranked))
+	}
+
+	if ranked[0].Name != "alice" || ranked[0].Wins != 2 {
+		t.Errorf("ranked[0] = %+v, want alice with 2 wins", ranked[0])
+	}
+}
+
+func TestMemoryStoreLeaderboardZeroOrLessReturnsAll(t *testing.T) {
+	s := NewMemoryStore()
+
+	s.RecordMatch(MatchResult{
+		Winner: "alice",
+		Players: [2]MatchPlayer{
+			{Name: "alice", Character: "max"},
+			{Name: "bob", Character: "drax"},
+		},
+	})
+
+	ranked, err := s.Leaderboard(0)
+
+	if err != nil {
+		t.Fatalf("Leaderboard: %v", err)
+	}
+
+	if len(ranked) != 2 {
+		t.Errorf("len(ranked) = %d, want 2 (every recorded player)", len(ranked))
+	}
+}
+
+func TestPlayerRecordAverageDuration(t *testing.T) {
+	rec := PlayerRecord{MatchCount: 2, TotalDuration: 10 * time.Second}
+
+	if rec.AverageDuration() != 5*time.Second {
+		t.Errorf("AverageDuration = %v, want 5s", rec.AverageDuration())
+	}
+
+	empty := PlayerRecord{}
+
+	if empty.AverageDuration() != 0 {
+		t.Errorf("AverageDuration = %v, want 0 for no matches", empty.AverageDuration())
+	}
+}
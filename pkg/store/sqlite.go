@@ -0,0 +1,203 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS players (
+	name              TEXT PRIMARY KEY,
+	wins              INTEGER NOT NULL DEFAULT 0,
+	losses            INTEGER NOT NULL DEFAULT 0,
+	match_count       INTEGER NOT NULL DEFAULT 0,
+	total_duration_ns INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS character_usage (
+	player    TEXT NOT NULL,
+	character TEXT NOT NULL,
+	uses      INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (player, character)
+);
+
+CREATE TABLE IF NOT EXISTS moves (
+	room_id   TEXT NOT NULL,
+	player    TEXT NOT NULL,
+	move_key  TEXT NOT NULL,
+	played_at INTEGER NOT NULL
+);
+`
+
+// SQLiteStore is the default Store, backing the live server with an on-disk
+// SQLite database so matches and player stats survive a restart.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (creating if necessary) the SQLite database at path
+// and applies its schema.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) RecordMatch(result MatchResult) error {
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range result.Players {
+		_, err := tx.Exec(`
+			INSERT INTO players (name, match_count, total_duration_ns)
+			VALUES (?, 1, ?)
+			ON CONFLICT(name) DO UPDATE SET
+				match_count = match_count + 1,
+				total_duration_ns = total_duration_ns + excluded.total_duration_ns
+		`, p.Name, int64(result.Duration))
+
+		if err != nil {
+			return err
+		}
+
+		switch result.Winner {
+		case "":
+		case p.Name:
+			if _, err := tx.Exec(`UPDATE players SET wins = wins + 1 WHERE name = ?`, p.Name); err != nil {
+				return err
+			}
+		default:
+			if _, err := tx.Exec(`UPDATE players SET losses = losses + 1 WHERE name = ?`, p.Name); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO character_usage (player, character, uses)
+			VALUES (?, ?, 1)
+			ON CONFLICT(player, character) DO UPDATE SET uses = uses + 1
+		`, p.Name, p.Character)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, mv := range result.Moves {
+		_, err := tx.Exec(`
+			INSERT INTO moves (room_id, player, move_key, played_at) VALUES (?, ?, ?, ?)
+		`, result.RoomID, mv.Player, mv.Key, mv.At.UnixNano())
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) LoadPlayer(name string) (PlayerRecord, error) {
+	rec := PlayerRecord{Name: name, CharacterUsage: make(map[string]int)}
+
+	var totalNs int64
+
+	row := s.db.QueryRow(`
+		SELECT wins, losses, match_count, total_duration_ns FROM players WHERE name = ?
+	`, name)
+
+	switch err := row.Scan(&rec.Wins, &rec.Losses, &rec.MatchCount, &totalNs); err {
+	case sql.ErrNoRows:
+		return rec, nil
+	case nil:
+	default:
+		return PlayerRecord{}, err
+	}
+
+	rec.TotalDuration = time.Duration(totalNs)
+
+	rows, err := s.db.Query(`SELECT character, uses FROM character_usage WHERE player = ?`, name)
+
+	if err != nil {
+		return PlayerRecord{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var character string
+		var uses int
+
+		if err := rows.Scan(&character, &uses); err != nil {
+			return PlayerRecord{}, err
+		}
+
+		rec.CharacterUsage[character] = uses
+	}
+
+	return rec, rows.Err()
+}
+
+func (s *SQLiteStore) Leaderboard(n int) ([]PlayerRecord, error) {
+	limit := n
+
+	if limit <= 0 {
+		// SQLite's LIMIT -1 means unlimited, matching MemoryStore's
+		// n<=0-means-everything behavior.
+		limit = -1
+	}
+
+	rows, err := s.db.Query(`SELECT name FROM players ORDER BY wins DESC LIMIT ?`, limit)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+
+	for rows.Next() {
+		var name string
+
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	records := make([]PlayerRecord, 0, len(names))
+
+	for _, name := range names {
+		rec, err := s.LoadPlayer(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
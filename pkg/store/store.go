@@ -0,0 +1,60 @@
+// Package store persists per-player match history behind a small, pluggable
+// interface: a default SQLite-backed implementation for the running server,
+// and an in-memory one for tests.
+package store
+
+import "time"
+
+// MatchPlayer is one side of a finished match, for RecordMatch.
+type MatchPlayer struct {
+	Name      string
+	Character string
+}
+
+// MoveLogEntry is a single input intent applied during a match, recorded so
+// the match can be replayed later.
+type MoveLogEntry struct {
+	Player string
+	Key    string
+	At     time.Time
+}
+
+// MatchResult is everything about one finished match worth persisting.
+type MatchResult struct {
+	RoomID   string
+	Winner   string // empty means a draw
+	Players  [2]MatchPlayer
+	Duration time.Duration
+	Moves    []MoveLogEntry
+	PlayedAt time.Time
+}
+
+// PlayerRecord is a player's accumulated stats across every recorded match.
+type PlayerRecord struct {
+	Name           string
+	Wins           int
+	Losses         int
+	MatchCount     int
+	TotalDuration  time.Duration
+	CharacterUsage map[string]int
+}
+
+// AverageDuration is TotalDuration spread evenly across MatchCount matches.
+func (r PlayerRecord) AverageDuration() time.Duration {
+	if r.MatchCount == 0 {
+		return 0
+	}
+
+	return r.TotalDuration / time.Duration(r.MatchCount)
+}
+
+// Store persists match history and serves it back for the stats and
+// leaderboard actions.
+type Store interface {
+	RecordMatch(result MatchResult) error
+	LoadPlayer(name string) (PlayerRecord, error)
+	// Leaderboard returns up to the top n PlayerRecords by wins, descending.
+	// n<=0 means return every player on record.
+	Leaderboard(n int) ([]PlayerRecord, error)
+	Close() error
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/i4ki/ubt-server/pkg/proto"
+	"github.com/i4ki/ubt-server/pkg/sim"
+	"github.com/i4ki/ubt-server/pkg/store"
+)
+
+const (
+	actionStats       = "stats"
+	actionLeaderboard = "leaderboard"
+
+	defaultLeaderboardSize = 10
+)
+
+type (
+	characterUsageView struct {
+		Character string `json:"character"`
+		Uses      int    `json:"uses"`
+	}
+
+	playerRecordView struct {
+		Name            string               `json:"name"`
+		Wins            int                  `json:"wins"`
+		Losses          int                  `json:"losses"`
+		MatchCount      int                  `json:"match_count"`
+		AverageDuration string               `json:"average_duration"`
+		CharacterUsage  []characterUsageView `json:"character_usage"`
+	}
+
+	statsMessage struct {
+		Status string            `json:"status"`
+		Code   StatusCode        `json:"code"`
+		Player *playerRecordView `json:"player,omitempty"`
+	}
+
+	leaderboardMessage struct {
+		Status string             `json:"status"`
+		Code   StatusCode         `json:"code"`
+		Ranked []playerRecordView `json:"ranked,omitempty"`
+	}
+)
+
+// newPlayerRecordView converts a store.PlayerRecord into its wire format,
+// flattening the character usage map into a stable, ordered slice.
+func newPlayerRecordView(rec store.PlayerRecord) playerRecordView {
+	usage := make([]characterUsageView, 0, len(rec.CharacterUsage))
+
+	for character, uses := range rec.CharacterUsage {
+		usage = append(usage, characterUsageView{Character: character, Uses: uses})
+	}
+
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Character < usage[j].Character
+	})
+
+	return playerRecordView{
+		Name:            rec.Name,
+		Wins:            rec.Wins,
+		Losses:          rec.Losses,
+		MatchCount:      rec.MatchCount,
+		AverageDuration: rec.AverageDuration().String(),
+		CharacterUsage:  usage,
+	}
+}
+
+// handleStatsAction loads and sends back a single player's stats.
+func handleStatsAction(conn net.Conn, st store.Store, playerName string) error {
+	rec, err := st.LoadPlayer(playerName)
+
+	if err != nil {
+		return sendMessage(conn, proto.Connect, statsMessage{
+			Status: err.Error(),
+			Code:   EInternal,
+		})
+	}
+
+	view := newPlayerRecordView(rec)
+
+	return sendMessage(conn, proto.Connect, statsMessage{
+		Status: "ok",
+		Code:   ESuccess,
+		Player: &view,
+	})
+}
+
+// handleLeaderboardAction loads and sends back the top n players by wins.
+func handleLeaderboardAction(conn net.Conn, st store.Store, n int) error {
+	if n <= 0 {
+		n = defaultLeaderboardSize
+	}
+
+	records, err := st.Leaderboard(n)
+
+	if err != nil {
+		return sendMessage(conn, proto.Connect, leaderboardMessage{
+			Status: err.Error(),
+			Code:   EInternal,
+		})
+	}
+
+	ranked := make([]playerRecordView, 0, len(records))
+
+	for _, rec := range records {
+		ranked = append(ranked, newPlayerRecordView(rec))
+	}
+
+	return sendMessage(conn, proto.Connect, leaderboardMessage{
+		Status: "ok",
+		Code:   ESuccess,
+		Ranked: ranked,
+	})
+}
+
+// recordMatch builds a store.MatchResult from room's finished match and
+// persists it, logging but not failing the room teardown if it errors.
+// moves is a snapshot of room.moveLog taken under room's lock by the
+// caller, since the log can still be appended to concurrently right up
+// until snapshot.Over is observed.
+func recordMatch(room *Room, snapshot sim.Snapshot, moves []store.MoveLogEntry) {
+	if room.store == nil {
+		return
+	}
+
+	result := store.MatchResult{
+		RoomID:   string(room.ID),
+		Winner:   snapshot.Winner,
+		Players:  room.matchPlayers,
+		Duration: time.Since(room.startedAt),
+		Moves:    moves,
+		PlayedAt: room.startedAt,
+	}
+
+	if err := room.store.RecordMatch(result); err != nil {
+		fmt.Printf("ERROR: failed to record match %s: %s\n", room.ID, err.Error())
+	}
+}